@@ -0,0 +1,86 @@
+package datastore
+
+import (
+	"context"
+
+	pb "github.com/authzed/spicedb/pkg/REDACTEDapi/api"
+)
+
+// TupleQuery is a builder for constructing a query against the set of
+// stored relation tuples. Each With* method returns a new TupleQuery with
+// the additional filter applied, so queries can be built up incrementally
+// without mutating a shared instance.
+type TupleQuery interface {
+	WithObjectID(objectID string) TupleQuery
+	WithObjectIDs(objectIDs []string) TupleQuery
+	WithObjectNamespace(namespace string) TupleQuery
+	WithRelation(relation string) TupleQuery
+	WithUserset(userset *pb.ObjectAndRelation) TupleQuery
+	WithUsersets(usersets []*pb.ObjectAndRelation) TupleQuery
+
+	// Execute runs the query and returns an iterator over the results.
+	// Implementations must respect ctx cancellation/deadlines for the
+	// lifetime of the returned iterator.
+	Execute(ctx context.Context) (TupleIterator, error)
+}
+
+// TupleReader is the read side of the datastore: looking up tuples either
+// from the (namespace, object_id, relation) side or from the userset
+// (subject) side, as of a fixed revision.
+type TupleReader interface {
+	// QueryTuples looks up tuples for a namespace as of the given
+	// revision, starting from the (namespace, object_id, relation) side
+	// of the tuple.
+	QueryTuples(namespace string, revision uint64) TupleQuery
+
+	// ReverseQueryTuples is the dual of QueryTuples: it looks up tuples
+	// as of the given revision starting from the userset (subject) side,
+	// letting callers filter down to the object namespace/relation they
+	// care about. This is what a LookupResources-style API is built on:
+	// given a subject, find every object they have some relation to.
+	ReverseQueryTuples(subject *pb.ObjectAndRelation, revision uint64) TupleQuery
+}
+
+// Datastore is the persistence layer backing the permissions check/expand
+// engine. Implementations must be safe for concurrent use.
+type Datastore interface {
+	TupleReader
+
+	// SnapshotReader pins a single MVCC snapshot as of revision and
+	// returns a TupleReader backed by it, so every QueryTuples /
+	// ReverseQueryTuples issued through it sees an identical view of the
+	// data even if writes land concurrently. The returned close function
+	// must be called exactly once when the caller is done with it.
+	SnapshotReader(ctx context.Context, revision uint64) (reader SnapshotReader, close func() error, err error)
+
+	// Close releases any resources held by the datastore, such as
+	// connections and cached prepared statements. The datastore must not
+	// be used after Close returns.
+	Close() error
+}
+
+// SnapshotReader is a TupleReader bound to a single, fixed MVCC snapshot.
+// Callers that need several queries to observe one consistent view of the
+// data (e.g. every subquery in a single permission check) should acquire
+// one via Datastore.SnapshotReader instead of issuing queries directly
+// against the Datastore.
+type SnapshotReader interface {
+	TupleReader
+}
+
+// TupleIterator is a (possibly lazy) stream of relation tuples matching a
+// TupleQuery. Callers must call Close once they are done consuming the
+// iterator, whether or not it was fully drained.
+type TupleIterator interface {
+	// Next returns the next tuple in the result set, or nil if the
+	// iterator has been exhausted. Callers should check Err after a nil
+	// result to distinguish "done" from "failed".
+	Next() *pb.RelationTuple
+
+	// Err returns any error encountered while iterating.
+	Err() error
+
+	// Close releases any resources (e.g. DB rows, connections) held by
+	// the iterator. It is safe to call Close without fully draining Next.
+	Close()
+}
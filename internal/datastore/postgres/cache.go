@@ -0,0 +1,228 @@
+package postgres
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultMaxCachedQueryPlans is used when a pgDatastore is constructed
+// without WithMaxCachedQueryPlans.
+const defaultMaxCachedQueryPlans = 256
+
+// PostgresOption configures optional, non-default behavior on a
+// pgDatastore.
+type PostgresOption func(*pgDatastore)
+
+// WithMaxCachedQueryPlans bounds how many distinct query shapes (SQL
+// templates with placeholders, not bound argument values) are kept
+// prepared against the connection at once. Least-recently-used shapes
+// are evicted first. A size of zero disables the cache.
+func WithMaxCachedQueryPlans(size int) PostgresOption {
+	return func(pgd *pgDatastore) {
+		if size <= 0 {
+			pgd.stmtCache = nil
+			return
+		}
+		pgd.stmtCache = newQueryPlanCache(size)
+	}
+}
+
+var (
+	queryPlanCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "datastore_postgres",
+		Name:      "query_plan_cache_hits_total",
+		Help:      "Number of tuple queries served by an already-prepared statement.",
+	})
+	queryPlanCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "spicedb",
+		Subsystem: "datastore_postgres",
+		Name:      "query_plan_cache_misses_total",
+		Help:      "Number of tuple queries that required preparing a new statement.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(queryPlanCacheHits, queryPlanCacheMisses)
+}
+
+// queryPlanCache is a small LRU of prepared statements keyed by SQL
+// template. Check traversal issues the same handful of query shapes
+// millions of times; caching the prepared statement avoids re-parsing
+// and re-planning each one on every call.
+//
+// Entries are refcounted: acquire() hands out an entry still pinned by
+// the caller's in-flight query, and evicting an entry that is still pinned
+// only marks it evicted rather than closing it. The underlying *sqlx.Stmt is only
+// closed once its refcount drops to zero, so a statement being evicted
+// from the LRU can never be closed out from under a query that's still
+// using it.
+type queryPlanCache struct {
+	maxSize int
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type queryPlanEntry struct {
+	sql      string
+	stmt     *sqlx.Stmt
+	refCount int
+	evicted  bool
+}
+
+// preparer is satisfied by both *sqlx.DB (pgDatastore's connection pool)
+// and *sqlx.Tx (a single SnapshotReader's transaction), so a
+// queryPlanCache can prepare statements against whichever one owns the
+// query. A prepared statement is scoped to the connection it was
+// prepared on, so a pool-wide cache's entries can't be reused inside a
+// transaction; pgSnapshotReader instead owns its own short-lived cache
+// (see SnapshotReader) built on the same queryPlanCache type.
+type preparer interface {
+	PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error)
+}
+
+func newQueryPlanCache(maxSize int) *queryPlanCache {
+	if maxSize <= 0 {
+		maxSize = defaultMaxCachedQueryPlans
+	}
+
+	return &queryPlanCache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// acquire returns a cached, pinned *queryPlanEntry for sql, preparing and
+// caching one if this query shape hasn't been seen yet. The caller must
+// call release on the returned entry once it's done with the statement
+// (i.e. once the rows it produced have been closed).
+func (c *queryPlanCache) acquire(ctx context.Context, db preparer, sql string) (*queryPlanEntry, error) {
+	if entry, ok := c.lookup(sql); ok {
+		queryPlanCacheHits.Inc()
+		return entry, nil
+	}
+
+	queryPlanCacheMisses.Inc()
+
+	stmt, err := db.PreparexContext(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("unable to prepare query: %w", err)
+	}
+
+	return c.store(sql, stmt), nil
+}
+
+func (c *queryPlanCache) lookup(sql string) (*queryPlanEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[sql]
+	if !ok {
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	entry := el.Value.(*queryPlanEntry)
+	entry.refCount++
+	return entry, true
+}
+
+func (c *queryPlanCache) store(sql string, stmt *sqlx.Stmt) *queryPlanEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another caller may have raced us to prepare the same shape; prefer
+	// the winner's entry and close the redundant statement.
+	if el, ok := c.entries[sql]; ok {
+		stmt.Close()
+		c.ll.MoveToFront(el)
+		entry := el.Value.(*queryPlanEntry)
+		entry.refCount++
+		return entry
+	}
+
+	entry := &queryPlanEntry{sql: sql, stmt: stmt, refCount: 1}
+	el := c.ll.PushFront(entry)
+	c.entries[sql] = el
+
+	if c.ll.Len() > c.maxSize {
+		c.evict(c.ll.Back())
+	}
+
+	return entry
+}
+
+// evict removes el from the LRU. The entry's statement is only closed
+// once release() observes its refcount reaching zero; if it's still
+// pinned by an in-flight query, closing is deferred to that release.
+func (c *queryPlanCache) evict(el *list.Element) {
+	entry := el.Value.(*queryPlanEntry)
+	delete(c.entries, entry.sql)
+	c.ll.Remove(el)
+	entry.evicted = true
+	if entry.refCount == 0 {
+		entry.stmt.Close()
+	}
+}
+
+// release unpins entry after the caller is done using its statement,
+// closing it if it was evicted while still in use.
+func (c *queryPlanCache) release(entry *queryPlanEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.refCount--
+	if entry.refCount == 0 && entry.evicted {
+		entry.stmt.Close()
+	}
+}
+
+// Close releases resources held by the datastore: any prepared statements
+// cached via WithMaxCachedQueryPlans, then the underlying connection pool.
+func (pgd *pgDatastore) Close() error {
+	var firstErr error
+	if pgd.stmtCache != nil {
+		if err := pgd.stmtCache.Close(); err != nil {
+			firstErr = err
+		}
+	}
+
+	if err := pgd.db.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+// Close evicts and releases every prepared statement held by the cache.
+// Entries still pinned by an in-flight query are closed as soon as that
+// query releases them rather than immediately.
+func (c *queryPlanCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		entry := el.Value.(*queryPlanEntry)
+		delete(c.entries, entry.sql)
+		c.ll.Remove(el)
+		entry.evicted = true
+		if entry.refCount == 0 {
+			if err := entry.stmt.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		el = next
+	}
+
+	return firstErr
+}
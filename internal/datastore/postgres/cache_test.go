@@ -0,0 +1,228 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The fake driver below exists only so these tests can exercise
+// queryPlanCache's prepare/close bookkeeping without a real Postgres
+// connection: it never executes a query, it just tracks which statements
+// were prepared and closed.
+
+var fakeDriverSeq int64
+
+type fakeDriver struct {
+	mu    sync.Mutex
+	stmts []*fakeStmt
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{driver: d}, nil
+}
+
+func (d *fakeDriver) record(s *fakeStmt) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stmts = append(d.stmts, s)
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	s := &fakeStmt{}
+	c.driver.record(s)
+	return s, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *fakeStmt) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeStmt) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return nil }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+// newFakeDB registers and opens a fresh fake driver instance, so the
+// *fakeDriver it returns only ever sees prepares made by this test.
+func newFakeDB(t *testing.T) (*sqlx.DB, *fakeDriver) {
+	t.Helper()
+
+	d := &fakeDriver{}
+	name := fmt.Sprintf("postgres-cache-fake-%d", atomic.AddInt64(&fakeDriverSeq, 1))
+	sql.Register(name, d)
+
+	db, err := sqlx.Open(name, "fake")
+	if err != nil {
+		t.Fatalf("unable to open fake db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, d
+}
+
+func TestQueryPlanCacheEvictionWhilePinned(t *testing.T) {
+	ctx := context.Background()
+	db, drv := newFakeDB(t)
+	cache := newQueryPlanCache(1)
+
+	entryA, err := cache.acquire(ctx, db, "SELECT a")
+	if err != nil {
+		t.Fatalf("acquire a: %v", err)
+	}
+
+	// maxSize is 1, so acquiring a second shape evicts entryA's LRU slot
+	// even though entryA is still pinned by the caller above.
+	entryB, err := cache.acquire(ctx, db, "SELECT b")
+	if err != nil {
+		t.Fatalf("acquire b: %v", err)
+	}
+	defer cache.release(entryB)
+
+	if !entryA.evicted {
+		t.Fatal("expected entryA to be marked evicted once the LRU was full")
+	}
+	if drv.stmts[0].isClosed() {
+		t.Fatal("entryA's statement was closed while still pinned by its caller")
+	}
+}
+
+func TestQueryPlanCacheReleaseAfterEvictClosesStmt(t *testing.T) {
+	ctx := context.Background()
+	db, drv := newFakeDB(t)
+	cache := newQueryPlanCache(1)
+
+	entryA, err := cache.acquire(ctx, db, "SELECT a")
+	if err != nil {
+		t.Fatalf("acquire a: %v", err)
+	}
+
+	entryB, err := cache.acquire(ctx, db, "SELECT b")
+	if err != nil {
+		t.Fatalf("acquire b: %v", err)
+	}
+	defer cache.release(entryB)
+
+	cache.release(entryA)
+
+	if !drv.stmts[0].isClosed() {
+		t.Fatal("expected entryA's statement to be closed once its last reference was released")
+	}
+}
+
+func TestQueryPlanCacheStoreRace(t *testing.T) {
+	ctx := context.Background()
+	db, drv := newFakeDB(t)
+	cache := newQueryPlanCache(defaultMaxCachedQueryPlans)
+
+	winner, err := cache.acquire(ctx, db, "SELECT race")
+	if err != nil {
+		t.Fatalf("acquire winner: %v", err)
+	}
+	defer cache.release(winner)
+
+	// Simulate a second goroutine that missed the cache at the same
+	// instant, prepared its own statement for the same shape, and is now
+	// storing it.
+	loserStmt, err := db.PreparexContext(ctx, "SELECT race")
+	if err != nil {
+		t.Fatalf("prepare loser: %v", err)
+	}
+	loser := cache.store("SELECT race", loserStmt)
+	defer cache.release(loser)
+
+	if loser != winner {
+		t.Fatal("expected the losing store() call to return the winner's entry")
+	}
+	if winner.refCount != 2 {
+		t.Fatalf("expected refCount 2 after both callers acquired the same entry, got %d", winner.refCount)
+	}
+	if len(drv.stmts) != 2 {
+		t.Fatalf("expected two underlying prepares (winner + loser), got %d", len(drv.stmts))
+	}
+	if !drv.stmts[1].isClosed() {
+		t.Fatal("expected the redundant (losing) prepared statement to be closed")
+	}
+}
+
+func TestQueryPlanCacheConcurrentAcquireSameShape(t *testing.T) {
+	ctx := context.Background()
+	db, _ := newFakeDB(t)
+	cache := newQueryPlanCache(defaultMaxCachedQueryPlans)
+
+	const n = 16
+	entries := make([]*queryPlanEntry, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			entry, err := cache.acquire(ctx, db, "SELECT concurrent")
+			if err != nil {
+				t.Errorf("acquire: %v", err)
+				return
+			}
+			entries[i] = entry
+		}(i)
+	}
+	wg.Wait()
+
+	first := entries[0]
+	for _, e := range entries {
+		if e != first {
+			t.Fatal("expected every concurrent acquire of the same shape to return the same entry")
+		}
+	}
+
+	for range entries {
+		cache.release(first)
+	}
+
+	if first.refCount != 0 {
+		t.Fatalf("expected refCount 0 after releasing every acquire, got %d", first.refCount)
+	}
+}
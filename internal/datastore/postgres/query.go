@@ -1,14 +1,17 @@
 package postgres
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"runtime"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
-	"github.com/jmoiron/sqlx"
 	"github.com/authzed/spicedb/internal/datastore"
 	pb "github.com/authzed/spicedb/pkg/REDACTEDapi/api"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 const errUnableToQueryTuples = "unable to query tuples: %w"
@@ -28,23 +31,35 @@ var (
 
 func (pgd *pgDatastore) QueryTuples(namespace string, revision uint64) datastore.TupleQuery {
 	return pgTupleQuery{
-		db: pgd.db,
-		query: queryTuples.
-			Where(sq.Eq{colNamespace: namespace}).
-			Where(sq.LtOrEq{colCreatedTxn: revision}).
-			Where(sq.Or{
-				sq.Eq{colDeletedTxn: liveDeletedTxnID},
-				sq.Gt{colDeletedTxn: revision},
-			}),
+		db:        pgd.db,
+		query:     liveAtRevision(queryTuples.Where(sq.Eq{colNamespace: namespace}), revision),
 		namespace: namespace,
+		stmtCache: pgd.stmtCache,
+		observer:  pgd.observer,
 	}
 }
 
+// liveAtRevision narrows query down to the rows that were live as of
+// revision: created at or before it, and either never deleted or deleted
+// after it. QueryTuples, ReverseQueryTuples, and SnapshotReader all build
+// on this.
+func liveAtRevision(query sq.SelectBuilder, revision uint64) sq.SelectBuilder {
+	return query.
+		Where(sq.LtOrEq{colCreatedTxn: revision}).
+		Where(sq.Or{
+			sq.Eq{colDeletedTxn: liveDeletedTxnID},
+			sq.Gt{colDeletedTxn: revision},
+		})
+}
+
 type pgTupleQuery struct {
 	db        *sqlx.DB
+	tx        *sqlx.Tx
 	query     sq.SelectBuilder
 	namespace string
 	relation  string
+	stmtCache *queryPlanCache
+	observer  Observer
 }
 
 func (ptq pgTupleQuery) WithObjectID(objectID string) datastore.TupleQuery {
@@ -52,6 +67,12 @@ func (ptq pgTupleQuery) WithObjectID(objectID string) datastore.TupleQuery {
 	return ptq
 }
 
+func (ptq pgTupleQuery) WithObjectNamespace(namespace string) datastore.TupleQuery {
+	ptq.query = ptq.query.Where(sq.Eq{colNamespace: namespace})
+	ptq.namespace = namespace
+	return ptq
+}
+
 func (ptq pgTupleQuery) WithRelation(relation string) datastore.TupleQuery {
 	ptq.query = ptq.query.Where(sq.Eq{colRelation: relation})
 	ptq.relation = relation
@@ -67,58 +88,96 @@ func (ptq pgTupleQuery) WithUserset(userset *pb.ObjectAndRelation) datastore.Tup
 	return ptq
 }
 
-func (ptq pgTupleQuery) Execute() (datastore.TupleIterator, error) {
-	tx, err := ptq.db.Beginx()
-	if err != nil {
-		return nil, fmt.Errorf(errUnableToQueryTuples, err)
+// WithObjectIDs batches a set of object IDs into a single
+// "object_id = ANY(...)" array bind, so walking N object IDs costs one
+// round-trip instead of N. The SQL template is the same regardless of
+// how many IDs are passed, unlike squirrel's sq.Eq-with-slice (which
+// emits a different "IN (?,?,...)" shape per N) — that keeps this query
+// plan-cacheable instead of churning the LRU in cache.go with a new
+// entry per batch size.
+func (ptq pgTupleQuery) WithObjectIDs(objectIDs []string) datastore.TupleQuery {
+	if len(objectIDs) == 0 {
+		// An empty candidate set (e.g. a LookupResources caller that
+		// narrowed down to nothing) must match nothing, not silently
+		// leave this filter off and match every object ID in scope.
+		ptq.query = ptq.query.Where(sq.Expr("1 = 0"))
+		return ptq
 	}
-	defer tx.Rollback()
 
-	if err != nil {
-		return nil, err
+	clause := fmt.Sprintf("%s = ANY(?)", colObjectID)
+	ptq.query = ptq.query.Where(sq.Expr(clause, pq.Array(objectIDs)))
+	return ptq
+}
+
+// WithUsersets batches a set of subjects into a single
+// "(userset_namespace, userset_object_id, userset_relation) IN (SELECT
+// ... FROM unnest(...))" clause bound against three parallel arrays, so
+// walking a userset rewrite over N subjects costs one round-trip instead
+// of N. Binding via unnest (rather than one "(?,?,?)" tuple per subject)
+// keeps the SQL template fixed regardless of N, so it stays
+// plan-cacheable instead of evicting genuinely hot shapes from the LRU
+// in cache.go with a new entry per batch size.
+func (ptq pgTupleQuery) WithUsersets(usersets []*pb.ObjectAndRelation) datastore.TupleQuery {
+	if len(usersets) == 0 {
+		// Same reasoning as WithObjectIDs: an empty subject set must
+		// match nothing rather than leaving the filter off entirely.
+		ptq.query = ptq.query.Where(sq.Expr("1 = 0"))
+		return ptq
 	}
 
-	sql, args, err := ptq.query.ToSql()
-	if err != nil {
-		return nil, fmt.Errorf(errUnableToQueryTuples, err)
+	namespaces := make([]string, len(usersets))
+	objectIDs := make([]string, len(usersets))
+	relations := make([]string, len(usersets))
+	for i, userset := range usersets {
+		namespaces[i] = userset.Namespace
+		objectIDs[i] = userset.ObjectId
+		relations[i] = userset.Relation
+	}
+
+	clause := fmt.Sprintf(
+		"(%s, %s, %s) IN (SELECT * FROM unnest(?::text[], ?::text[], ?::text[]))",
+		colUsersetNamespace,
+		colUsersetObjectID,
+		colUsersetRelation,
+	)
+
+	ptq.query = ptq.query.Where(sq.Expr(
+		clause,
+		pq.Array(namespaces),
+		pq.Array(objectIDs),
+		pq.Array(relations),
+	))
+	return ptq
+}
+
+func (ptq pgTupleQuery) Execute(ctx context.Context) (datastore.TupleIterator, error) {
+	observer := ptq.observer
+	if observer == nil {
+		observer = noopObserver{}
 	}
 
-	rows, err := ptq.db.Queryx(sql, args...)
+	sql, args, err := ptq.query.ToSql()
 	if err != nil {
 		return nil, fmt.Errorf(errUnableToQueryTuples, err)
 	}
 
-	var tuples []*pb.RelationTuple
-	for rows.Next() {
-		nextTuple := &pb.RelationTuple{
-			ObjectAndRelation: &pb.ObjectAndRelation{},
-			User: &pb.User{
-				UserOneof: &pb.User_Userset{
-					Userset: &pb.ObjectAndRelation{},
-				},
-			},
-		}
-		userset := nextTuple.User.GetUserset()
-		err := rows.Scan(
-			&nextTuple.ObjectAndRelation.Namespace,
-			&nextTuple.ObjectAndRelation.ObjectId,
-			&nextTuple.ObjectAndRelation.Relation,
-			&userset.Namespace,
-			&userset.ObjectId,
-			&userset.Relation,
-		)
-		if err != nil {
-			return nil, fmt.Errorf(errUnableToQueryTuples, err)
-		}
+	observer.OnQueryStart(ctx, ptq.namespace, ptq.relation, sql, args)
+	start := time.Now()
 
-		tuples = append(tuples, nextTuple)
-	}
-	if err := rows.Err(); err != nil {
+	rows, release, err := ptq.queryRows(ctx, sql, args)
+	if err != nil {
+		observer.OnQueryEnd(ctx, ptq.namespace, ptq.relation, 0, err, time.Since(start))
 		return nil, fmt.Errorf(errUnableToQueryTuples, err)
 	}
 
 	iter := &pgTupleIterator{
-		tuples: tuples,
+		rows:      rows,
+		release:   release,
+		ctx:       ctx,
+		observer:  observer,
+		namespace: ptq.namespace,
+		relation:  ptq.relation,
+		start:     start,
 	}
 
 	runtime.SetFinalizer(iter, func(iter *pgTupleIterator) {
@@ -134,10 +193,62 @@ func (ptq pgTupleQuery) Execute() (datastore.TupleIterator, error) {
 	return iter, nil
 }
 
+// queryRows runs sql/args against whichever connection this query is
+// bound to, via the query-plan cache when one is configured, otherwise a
+// plain (re-planned every time) query. A snapshot-bound query (tx != nil)
+// uses pgSnapshotReader's own short-lived cache rather than the pool-wide
+// one, since a prepared statement can't cross connections. The returned
+// release func must be called once the caller is done with the resulting
+// rows; it unpins any cached statement so eviction can finally close it.
+func (ptq pgTupleQuery) queryRows(ctx context.Context, sql string, args []interface{}) (*sqlx.Rows, func(), error) {
+	noopRelease := func() {}
+
+	if ptq.stmtCache == nil {
+		if ptq.tx != nil {
+			rows, err := ptq.tx.QueryxContext(ctx, sql, args...)
+			return rows, noopRelease, err
+		}
+		rows, err := ptq.db.QueryxContext(ctx, sql, args...)
+		return rows, noopRelease, err
+	}
+
+	var prep preparer
+	if ptq.tx != nil {
+		prep = ptq.tx
+	} else {
+		prep = ptq.db
+	}
+
+	entry, err := ptq.stmtCache.acquire(ctx, prep, sql)
+	if err != nil {
+		return nil, noopRelease, err
+	}
+
+	release := func() { ptq.stmtCache.release(entry) }
+
+	rows, err := entry.stmt.QueryxContext(ctx, args...)
+	if err != nil {
+		release()
+		return nil, noopRelease, err
+	}
+
+	return rows, release, nil
+}
+
+// pgTupleIterator streams rows from *sqlx.Rows one at a time, holding onto
+// the row cursor (and its underlying connection) until Close is called.
 type pgTupleIterator struct {
-	tuples []*pb.RelationTuple
-	closed bool
-	err    error
+	rows    *sqlx.Rows
+	release func()
+	closed  bool
+	err     error
+
+	ctx       context.Context
+	observer  Observer
+	namespace string
+	relation  string
+	start     time.Time
+	rowCount  int
 }
 
 func (pti *pgTupleIterator) Next() *pb.RelationTuple {
@@ -146,13 +257,36 @@ func (pti *pgTupleIterator) Next() *pb.RelationTuple {
 		return nil
 	}
 
-	if len(pti.tuples) > 0 {
-		first := pti.tuples[0]
-		pti.tuples = pti.tuples[1:]
-		return first
+	if !pti.rows.Next() {
+		if err := pti.rows.Err(); err != nil {
+			pti.err = fmt.Errorf(errUnableToQueryTuples, err)
+		}
+		return nil
+	}
+
+	nextTuple := &pb.RelationTuple{
+		ObjectAndRelation: &pb.ObjectAndRelation{},
+		User: &pb.User{
+			UserOneof: &pb.User_Userset{
+				Userset: &pb.ObjectAndRelation{},
+			},
+		},
+	}
+	userset := nextTuple.User.GetUserset()
+	if err := pti.rows.Scan(
+		&nextTuple.ObjectAndRelation.Namespace,
+		&nextTuple.ObjectAndRelation.ObjectId,
+		&nextTuple.ObjectAndRelation.Relation,
+		&userset.Namespace,
+		&userset.ObjectId,
+		&userset.Relation,
+	); err != nil {
+		pti.err = fmt.Errorf(errUnableToQueryTuples, err)
+		return nil
 	}
 
-	return nil
+	pti.rowCount++
+	return nextTuple
 }
 
 func (pti *pgTupleIterator) Err() error {
@@ -164,6 +298,9 @@ func (pti *pgTupleIterator) Close() {
 		panic("postgres iterator double closed")
 	}
 
-	pti.tuples = nil
+	pti.rows.Close()
+	pti.release()
 	pti.closed = true
-}
\ No newline at end of file
+
+	pti.observer.OnQueryEnd(pti.ctx, pti.namespace, pti.relation, pti.rowCount, pti.err, time.Since(pti.start))
+}
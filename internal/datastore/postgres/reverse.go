@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/authzed/spicedb/internal/datastore"
+	pb "github.com/authzed/spicedb/pkg/REDACTEDapi/api"
+)
+
+// ReverseQueryTuples is the dual of QueryTuples: it starts from the
+// userset_* columns and lets the caller narrow down to the object
+// namespace/relation they're interested in via WithObjectNamespace and
+// WithRelation. This backs a LookupResources-style API: given a subject,
+// find every object they hold some relation to.
+func (pgd *pgDatastore) ReverseQueryTuples(subject *pb.ObjectAndRelation, revision uint64) datastore.TupleQuery {
+	return pgTupleQuery{
+		db: pgd.db,
+		query: liveAtRevision(queryTuples.Where(sq.Eq{
+			colUsersetNamespace: subject.Namespace,
+			colUsersetObjectID:  subject.ObjectId,
+			colUsersetRelation:  subject.Relation,
+		}), revision),
+		// Seed the Observer labels from the subject side so telemetry
+		// isn't blank until/unless a caller narrows down to an object
+		// namespace/relation via WithObjectNamespace/WithRelation, which
+		// overwrite these.
+		namespace: subject.Namespace,
+		relation:  subject.Relation,
+		stmtCache: pgd.stmtCache,
+		observer:  pgd.observer,
+	}
+}
@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/authzed/spicedb/internal/datastore"
+	pb "github.com/authzed/spicedb/pkg/REDACTEDapi/api"
+)
+
+// SnapshotReader opens a single REPEATABLE READ, READ ONLY transaction and
+// returns a datastore.SnapshotReader backed by it. Every QueryTuples /
+// ReverseQueryTuples issued through the returned reader reuses that one
+// transaction, so a permission check that walks the userset tree with many
+// independent subqueries sees one consistent MVCC snapshot instead of
+// risking a different revision per subquery. The caller must invoke the
+// returned close function exactly once when done with the reader.
+//
+// graph.CheckDispatcher.Check is the intended caller: it acquires one
+// snapshot per top-level Check RPC and threads it through every
+// recursive subquery the userset traversal makes.
+func (pgd *pgDatastore) SnapshotReader(ctx context.Context, revision uint64) (datastore.SnapshotReader, func() error, error) {
+	tx, err := pgd.db.BeginTxx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open snapshot: %w", err)
+	}
+
+	// pgd.stmtCache's entries are prepared against a pooled connection,
+	// not this transaction's connection, so they can't be reused here.
+	// A single Check RPC still issues the same handful of query shapes
+	// many times while walking the userset tree, so give this snapshot
+	// its own cache, scoped to the transaction's lifetime.
+	stmtCache := newQueryPlanCache(defaultMaxCachedQueryPlans)
+
+	reader := &pgSnapshotReader{
+		tx:        tx,
+		stmtCache: stmtCache,
+		observer:  pgd.observer,
+	}
+
+	close := func() error {
+		cacheErr := stmtCache.Close()
+		txErr := tx.Rollback()
+		if cacheErr != nil {
+			return cacheErr
+		}
+		return txErr
+	}
+
+	return reader, close, nil
+}
+
+// pgSnapshotReader is a datastore.SnapshotReader backed by a single
+// *sqlx.Tx, reused across every query it builds, plus a query-plan cache
+// scoped to that same transaction.
+type pgSnapshotReader struct {
+	tx        *sqlx.Tx
+	stmtCache *queryPlanCache
+	observer  Observer
+}
+
+func (r *pgSnapshotReader) QueryTuples(namespace string, revision uint64) datastore.TupleQuery {
+	return pgTupleQuery{
+		tx:        r.tx,
+		query:     liveAtRevision(queryTuples.Where(sq.Eq{colNamespace: namespace}), revision),
+		namespace: namespace,
+		stmtCache: r.stmtCache,
+		observer:  r.observer,
+	}
+}
+
+func (r *pgSnapshotReader) ReverseQueryTuples(subject *pb.ObjectAndRelation, revision uint64) datastore.TupleQuery {
+	return pgTupleQuery{
+		tx: r.tx,
+		query: liveAtRevision(queryTuples.Where(sq.Eq{
+			colUsersetNamespace: subject.Namespace,
+			colUsersetObjectID:  subject.ObjectId,
+			colUsersetRelation:  subject.Relation,
+		}), revision),
+		// See postgres.(*pgDatastore).ReverseQueryTuples: seed the
+		// Observer labels from the subject side so telemetry isn't blank
+		// by default.
+		namespace: subject.Namespace,
+		relation:  subject.Relation,
+		stmtCache: r.stmtCache,
+		observer:  r.observer,
+	}
+}